@@ -0,0 +1,121 @@
+// Package adder builds a UnixFS DAG for files and directories passed to
+// Client.Put, the same way `ipfs add` does: files are chunked and laid
+// out with the balanced DAG builder, directories are assembled in an
+// in-memory MFS root so nested paths can be written in any order.
+package adder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-mfs"
+	dag "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	uih "github.com/ipfs/go-unixfs/importer/helpers"
+)
+
+// Adder builds a UnixFS DAG in dag, accumulating directory structure in
+// mroot as Add is called.
+type Adder struct {
+	ctx   context.Context
+	dag   ipld.DAGService
+	mroot *mfs.Root
+}
+
+// NewAdder creates an Adder that writes nodes into dagService.
+func NewAdder(ctx context.Context, dagService ipld.DAGService) (*Adder, error) {
+	mr, err := mfs.NewRoot(ctx, dagService, dag.EmptyDirNode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Adder{ctx: ctx, dag: dagService, mroot: mr}, nil
+}
+
+// MfsRoot returns the MFS root Add writes into. Callers that added a
+// directory use it to pull the finished directory's node back out by
+// name, since for a directory Add returns the CID of the MFS root that
+// wraps it under that name, not the directory's own CID.
+func (a *Adder) MfsRoot() (*mfs.Root, error) {
+	return a.mroot, nil
+}
+
+// Add adds file to the DAG. For a regular file it returns the file's root
+// CID. If file is a directory, fsys is required (an fs.File alone can't
+// be recursed), dirname names the tree's root within the MFS root
+// returned by MfsRoot (it defaults to file's own name), and the CID
+// returned is that of the MFS root itself.
+func (a *Adder) Add(file fs.File, dirname string, fsys fs.FS) (cid.Cid, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !info.IsDir() {
+		return a.addFile(file)
+	}
+	if fsys == nil {
+		return cid.Undef, fmt.Errorf("%s is a directory but no fs.FS was given to walk it", info.Name())
+	}
+	if dirname == "" {
+		dirname = info.Name()
+	}
+
+	err = fs.WalkDir(fsys, dirname, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return mfs.Mkdir(a.mroot, p, mfs.MkdirOpts{Mkparents: true, Flush: false})
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fcid, err := a.addFile(f)
+		if err != nil {
+			return err
+		}
+		nd, err := a.dag.Get(a.ctx, fcid)
+		if err != nil {
+			return err
+		}
+		return mfs.PutNode(a.mroot, p, nd)
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err := a.mroot.FlushMemFree(a.ctx); err != nil {
+		return cid.Undef, err
+	}
+	nd, err := a.mroot.GetDirectory().GetNode()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+// addFile chunks r and lays it out as a balanced UnixFS DAG, returning
+// the CID of its root node.
+func (a *Adder) addFile(r io.Reader) (cid.Cid, error) {
+	spl := chunker.NewSizeSplitter(r, chunker.DefaultBlockSize)
+	params := uih.DagBuilderParams{
+		Dagserv:   a.dag,
+		RawLeaves: true,
+		Maxlinks:  uih.DefaultLinksPerBlock,
+	}
+	db, err := params.New(spl)
+	if err != nil {
+		return cid.Undef, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
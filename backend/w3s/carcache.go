@@ -0,0 +1,89 @@
+package w3s
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	carv2bs "github.com/ipld/go-car/v2/blockstore"
+)
+
+// Compact drops every block in the local CAR cache that isn't reachable
+// from one of roots, and re-indexes the CARv2 file in place. It's a
+// no-op if this client wasn't configured with WithCARCache.
+func (c *client) Compact(ctx context.Context, roots []cid.Cid) error {
+	if c.carCache == nil {
+		return nil
+	}
+
+	dag := merkledag.NewDAGService(bserv.New(c.carCache, nil))
+	keep := map[cid.Cid]struct{}{}
+	for _, root := range roots {
+		err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(dag), root, func(c cid.Cid) bool {
+			_, seen := keep[c]
+			keep[c] = struct{}{}
+			return !seen
+		})
+		if err != nil {
+			return fmt.Errorf("walking %s: %w", root, err)
+		}
+	}
+
+	path := c.cfg.carCachePath
+	tmpPath := path + ".compact"
+	// c.cfg.carCachePath is a CARv2 file (pragma + v2 header + data +
+	// index), so the replacement has to be built the same way - a bare
+	// CARv1 stream here would pass os.Rename but fail to reopen, and by
+	// then the previously-working cache would already be clobbered.
+	tmp, err := carv2bs.OpenReadWrite(tmpPath, roots)
+	if err != nil {
+		return err
+	}
+	for target := range keep {
+		blk, err := c.carCache.Get(target)
+		if err != nil {
+			return err
+		}
+		if err := tmp.Put(blk); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Finalize(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	cbs, err := reopenCARCache(path, roots)
+	if err != nil {
+		return err
+	}
+	if err := c.carCache.Close(); err != nil {
+		return fmt.Errorf("closing previous car cache: %w", err)
+	}
+	c.carCache = cbs
+	c.bsvc = bserv.New(cbs, nil)
+	return nil
+}
+
+// reopenCARCache regenerates path's CARv2 index and returns a blockstore
+// backed by it, e.g. after the file was rewritten by something other
+// than the blockstore that normally maintains it (as Compact does).
+func reopenCARCache(path string, roots []cid.Cid) (*carv2bs.ReadWrite, error) {
+	bs, err := carv2bs.OpenReadWrite(path, roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := bs.Finalize(); err != nil {
+		return nil, err
+	}
+	return carv2bs.OpenReadWrite(path, roots)
+}
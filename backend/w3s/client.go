@@ -1,6 +1,7 @@
 package w3s
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -9,11 +10,13 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
-	"sync"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/alanshaw/go-carbites"
 	"github.com/filecoin-project/go-address"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
 	bserv "github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
@@ -23,15 +26,25 @@ import (
 	"github.com/ipfs/go-merkledag"
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipld/go-car"
+	carv2bs "github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-ipld-prime"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	"github.com/rclone/rclone/backend/w3s/adder"
 	w3http "github.com/rclone/rclone/backend/w3s/http"
 	rcfs "github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/pacer"
+	"golang.org/x/sync/errgroup"
 )
 
 const targetChunkSize = 1024 * 1024 * 10
 const iso8601 = "2006-01-02T15:04:05Z0700"
 
+// defaultConcurrency is the number of CAR chunks PutCar uploads at once
+// when the caller doesn't set WithConcurrency.
+const defaultConcurrency = 4
+
 // Client is a HTTP API client to the web3.storage service.
 type Client interface {
 	Get(context.Context, cid.Cid) (*w3http.Web3Response, error)
@@ -39,13 +52,39 @@ type Client interface {
 	//从ipfs直接下载文件对象，要求cid是文件
 	GetIpfsFile(context.Context, cid.Cid) (*w3http.Web3Response, error)
 
+	// GetSelector downloads the CAR for root (unless root is already in
+	// the WithCARCache cache), walks sel over it and writes only the
+	// matched UnixFS file(s) to w.
+	GetSelector(ctx context.Context, root cid.Cid, sel ipld.Node, w io.Writer) error
+	// GetPath is GetSelector for callers who'd rather express what they
+	// want with a text path (e.g. "Links/0/Hash/foo") than build an
+	// ipld.Node selector by hand.
+	GetPath(ctx context.Context, root cid.Cid, path string, w io.Writer) error
+
 	Put(context.Context, fs.File, ...PutOption) (cid.Cid, error)
 	PutCar(context.Context, io.Reader) (cid.Cid, error)
+	// PutCarWithSelector uploads only the blocks of car reachable from
+	// its root via sel.
+	PutCarWithSelector(ctx context.Context, car io.Reader, sel ipld.Node) (cid.Cid, error)
 	Status(context.Context, cid.Cid) (*Status, error)
 	StatusIpfs(ctx context.Context, cid2 cid.Cid) (*IpfsStatus, error)
 	List(ctx context.Context) (entries []MyStatusJson, err error)
 
 	PutRclone(ctx context.Context, in io.Reader, src rcfs.ObjectInfo, cfg putConfig) (cid.Cid, error)
+
+	// Compact drops every block in the local CAR cache that isn't
+	// reachable from one of roots, and re-indexes the CARv2 file in
+	// place. It's a no-op if this client wasn't configured with
+	// WithCARCache.
+	Compact(ctx context.Context, roots []cid.Cid) error
+
+	// SetUploadDeadline arranges for every upload in flight, and every
+	// one started afterwards, to be cancelled at t. A zero t disarms
+	// any deadline previously set.
+	SetUploadDeadline(t time.Time)
+	// SetDownloadDeadline does the same for in-flight and future
+	// downloads (Get, GetUsingIpfs, GetIpfsFile, GetSelector).
+	SetDownloadDeadline(t time.Time)
 }
 
 type PinStatus int
@@ -255,16 +294,24 @@ func (s *Status) UnmarshalJSON(b []byte) error {
 }
 
 type clientConfig struct {
-	token        string
-	endpoint     string
-	ipfsEndpoint string
-	ds           ds.Batching
+	token          string
+	endpoint       string
+	ipfsEndpoint   string
+	ds             ds.Batching
+	concurrency    int
+	carCachePath   string
+	requestTimeout time.Duration
 }
 
 type client struct {
-	cfg  *clientConfig
-	bsvc blockservice.BlockService
-	hc   *http.Client
+	cfg      *clientConfig
+	bsvc     blockservice.BlockService
+	hc       *http.Client
+	pacer    *pacer.Pacer
+	carCache *carv2bs.ReadWrite // non-nil iff WithCARCache was used
+
+	uploadDeadline   *deadlineTimer
+	downloadDeadline *deadlineTimer
 }
 
 // NewClient creates a new web3.storage API client.
@@ -281,69 +328,218 @@ func NewClient(options ...Option) (Client, error) {
 	if cfg.token == "" {
 		return nil, fmt.Errorf("missing auth token")
 	}
-	c := client{cfg: &cfg, hc: &http.Client{}}
-	if cfg.ds != nil {
+	if cfg.concurrency == 0 {
+		cfg.concurrency = defaultConcurrency
+	}
+	c := client{
+		cfg: &cfg,
+		hc:  &http.Client{},
+		pacer: pacer.New(
+			pacer.RetriesOption(10),
+			pacer.CalculatorOption(pacer.NewExponential(
+				pacer.MinSleep(100*time.Millisecond),
+				pacer.MaxSleep(30*time.Second),
+				pacer.DecayConstant(2),
+			)),
+		),
+		uploadDeadline:   newDeadlineTimer(),
+		downloadDeadline: newDeadlineTimer(),
+	}
+	switch {
+	case cfg.carCachePath != "":
+		cbs, err := carv2bs.OpenReadWrite(cfg.carCachePath, nil)
+		if err != nil {
+			return nil, fmt.Errorf("opening car cache: %w", err)
+		}
+		c.carCache = cbs
+		c.bsvc = bserv.New(cbs, nil)
+	case cfg.ds != nil:
 		c.bsvc = bserv.New(blockstore.NewBlockstore(cfg.ds), nil)
-	} else {
+	default:
 		ds := dssync.MutexWrap(ds.NewMapDatastore())
 		c.bsvc = bserv.New(blockstore.NewBlockstore(ds), nil)
 	}
 	return &c, nil
 }
 
-// TODO: retry
-func (c *client) sendCar(ctx context.Context, r io.Reader) (cid.Cid, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.endpoint+"/car", r)
-	if err != nil {
-		return cid.Undef, err
+// SetUploadDeadline arranges for every upload in flight, and every one
+// started afterwards, to be cancelled at t. A zero t disarms any
+// deadline previously set.
+func (c *client) SetUploadDeadline(t time.Time) {
+	c.uploadDeadline.setDeadline(t)
+}
+
+// SetDownloadDeadline does the same for in-flight and future downloads
+// (Get, GetUsingIpfs, GetIpfsFile, GetSelector).
+func (c *client) SetDownloadDeadline(t time.Time) {
+	c.downloadDeadline.setDeadline(t)
+}
+
+// sendCar uploads a single CAR chunk, retrying on network errors, 5xx and
+// 429 responses with exponential backoff and jitter. chunk must support
+// Seek so the body can be replayed on retry.
+func (c *client) sendCar(ctx context.Context, chunk io.ReadSeeker) (cid.Cid, error) {
+	ctx, cancel := withDeadlineTimer(ctx, c.uploadDeadline)
+	defer cancel()
+
+	var out cid.Cid
+	err := c.pacer.Call(func() (bool, error) {
+		// A fresh context.WithTimeout per attempt, not one shared across
+		// retries: WithRequestTimeout bounds a single round trip, and
+		// reusing one timed-out context across retries would eat into
+		// the budget chunk0-1's backoff/retry logic is supposed to get.
+		attemptCtx := ctx
+		if c.cfg.requestTimeout > 0 {
+			var rcancel context.CancelFunc
+			attemptCtx, rcancel = context.WithTimeout(ctx, c.cfg.requestTimeout)
+			defer rcancel()
+		}
+
+		if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		var body io.Reader = chunk
+		var commitProgress func()
+		if pt := progressTrackerFromContext(ctx); pt != nil {
+			body, commitProgress = pt.wrap(chunk)
+		}
+		req, err := http.NewRequestWithContext(attemptCtx, "POST", c.cfg.endpoint+"/car", body)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Add("Content-Type", "application/car")
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.cfg.token))
+		res, err := c.hc.Do(req)
+		if retry, err := shouldRetry(ctx, res, err); retry || err != nil {
+			return retry, err
+		}
+		// Only an attempt that's actually going to be treated as done
+		// (not retried) gets to fold its bytes into the shared sent
+		// count - otherwise a retried attempt's partial read would be
+		// double-counted once the next attempt re-reads the same bytes.
+		if commitProgress != nil {
+			commitProgress()
+		}
+		defer res.Body.Close()
+		d := json.NewDecoder(res.Body)
+		var body struct {
+			Cid string `json:"cid"`
+		}
+		if err := d.Decode(&body); err != nil {
+			return false, err
+		}
+		out, err = cid.Parse(body.Cid)
+		return false, err
+	})
+	return out, err
+}
+
+// shouldRetry decides whether an HTTP round trip should be retried, and
+// turns a 429/5xx response (or a transient network error) into an error
+// the pacer knows how to back off from. A Retry-After header given as a
+// number of seconds overrides the pacer's own backoff for that attempt;
+// one given as an HTTP date is ignored.
+func shouldRetry(ctx context.Context, res *http.Response, err error) (bool, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, ctxErr
 	}
-	req.Header.Add("Content-Type", "application/car")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.cfg.token))
-	res, err := c.hc.Do(req)
 	if err != nil {
-		return cid.Undef, err
+		return fserrors.ShouldRetry(err), err
 	}
-	if res.StatusCode != 200 {
-		return cid.Undef, fmt.Errorf("unexpected response status: %d", res.StatusCode)
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		err := fmt.Errorf("unexpected response status: %d", res.StatusCode)
+		res.Body.Close()
+		if ra := res.Header.Get("Retry-After"); ra != "" {
+			if secs, parseErr := strconv.Atoi(ra); parseErr == nil {
+				return true, pacer.RetryAfterError(err, time.Duration(secs)*time.Second)
+			}
+		}
+		return true, err
 	}
-	d := json.NewDecoder(res.Body)
-	var out struct {
-		Cid string `json:"cid"`
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return false, fmt.Errorf("unexpected response status: %d", res.StatusCode)
 	}
-	err = d.Decode(&out)
-	if err != nil {
-		return cid.Undef, err
+	return false, nil
+}
+
+// downloadRequestContext wraps ctx with the client's download deadline and
+// WithRequestTimeout, the same way sendCar and GetSelector do. Unlike
+// those, the caller of Get/GetUsingIpfs/GetIpfsFile keeps reading res.Body
+// after the call returns, so cancel must not fire on return - instead it's
+// wired into the returned body itself (see cancelOnCloseBody) so it fires
+// once the caller closes it, same as the deadline/parent ctx firing early.
+func (c *client) downloadRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := withDeadlineTimer(ctx, c.downloadDeadline)
+	if c.cfg.requestTimeout > 0 {
+		ctx, rcancel := context.WithTimeout(ctx, c.cfg.requestTimeout)
+		return ctx, func() { rcancel(); cancel() }
 	}
-	return cid.Parse(out.Cid)
+	return ctx, cancel
+}
+
+// cancelOnCloseBody releases a downloadRequestContext's resources (the
+// withDeadlineTimer goroutine watching for a deadline) once the caller is
+// done reading the response, instead of leaking it until the deadline fires
+// or the parent ctx is cancelled on its own.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
 }
 
 func (c *client) Get(ctx context.Context, cid cid.Cid) (*w3http.Web3Response, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/car/%s", c.cfg.endpoint, cid), nil)
+	ctx, cancel := c.downloadRequestContext(ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/car/%s", c.cfg.endpoint, cid), nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.cfg.token))
 	res, err := c.hc.Do(req)
-	return w3http.NewWeb3Response(res, c.bsvc), err
+	if err != nil {
+		cancel()
+		return w3http.NewWeb3Response(res, c.bsvc), err
+	}
+	res.Body = cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return w3http.NewWeb3Response(res, c.bsvc), nil
 }
 
 func (c *client) GetUsingIpfs(ctx context.Context, cid cid.Cid) (*w3http.Web3Response, error) {
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v0/dag/export?arg=%s", c.cfg.ipfsEndpoint, cid), nil)
+	ctx, cancel := c.downloadRequestContext(ctx)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v0/dag/export?arg=%s", c.cfg.ipfsEndpoint, cid), nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.cfg.token))
 	res, err := c.hc.Do(req)
-	return w3http.NewWeb3Response(res, c.bsvc), err
+	if err != nil {
+		cancel()
+		return w3http.NewWeb3Response(res, c.bsvc), err
+	}
+	res.Body = cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return w3http.NewWeb3Response(res, c.bsvc), nil
 }
 
 func (c *client) GetIpfsFile(ctx context.Context, cid cid.Cid) (*w3http.Web3Response, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s.ipfs.dweb.link/", cid), nil)
+	ctx, cancel := c.downloadRequestContext(ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s.ipfs.dweb.link/", cid), nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	res, err := c.hc.Do(req)
-	return w3http.NewWeb3Response(res, c.bsvc), err
+	if err != nil {
+		cancel()
+		return w3http.NewWeb3Response(res, c.bsvc), err
+	}
+	res.Body = cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return w3http.NewWeb3Response(res, c.bsvc), nil
 }
 
 func (c *client) List(ctx context.Context) ([]MyStatusJson, error) {
@@ -399,8 +595,9 @@ func (c *client) List(ctx context.Context) ([]MyStatusJson, error) {
 }
 
 type putConfig struct {
-	fsys    fs.FS
-	dirname string
+	fsys     fs.FS
+	dirname  string
+	progress func(sent, total int64)
 }
 
 type MyFile struct {
@@ -455,10 +652,29 @@ type ChangeName struct {
 
 func (c *client) PutRclone(ctx context.Context, in io.Reader, src rcfs.ObjectInfo, incfg putConfig) (cid.Cid, error) {
 
-	mc, e := c.Put(ctx, MyFile{src: src, in: in, cfg: &incfg}, func(cfg *putConfig) error {
-		cfg.dirname = incfg.dirname
-		return nil
-	})
+	// tr/acc give rclone's accounting package (and so `rclone copy -P`)
+	// something to show for this transfer. We don't wrap in itself for
+	// byte counting - in is source bytes, and what acc needs to show is
+	// uploaded CAR bytes, which only exist once UnixFS chunking and CAR
+	// framing have happened - so progress is fed in via
+	// ServerSideCopyProgress from the WithProgress callback instead.
+	tr := accounting.Stats(ctx).NewTransfer(src, nil)
+	acc := tr.Account(ctx, nil)
+	acc.ServerSideCopyStart()
+	defer func() {
+		_ = acc.Close()
+	}()
+
+	mc, e := c.Put(ctx, MyFile{src: src, in: in, cfg: &incfg},
+		func(cfg *putConfig) error {
+			cfg.dirname = incfg.dirname
+			return nil
+		},
+		WithProgress(func(sent, total int64) {
+			acc.ServerSideCopyProgress(sent)
+		}),
+	)
+	tr.Done(ctx, e)
 	if e != nil {
 		return cid.Undef, e
 	}
@@ -503,14 +719,53 @@ func (c *client) Put(ctx context.Context, file fs.File, options ...PutOption) (c
 		return cid.Undef, err
 	}
 
-	dag := merkledag.NewDAGService(c.bsvc)
+	if cfg.progress != nil {
+		ctx = WithProgressContext(ctx, cfg.progress)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Every block dagFmtr.Add writes is pushed onto blockCh as soon as
+	// it's produced, so assembleCarChunks can start shipping CAR chunks
+	// to the upload workers well before the DAG (and its root) is
+	// known. The CAR chunks held in memory are bounded by about
+	// targetChunkSize * concurrency, but tee also stores every block in
+	// c.bsvc's blockstore, which is in memory unless WithDatastore or
+	// WithCARCache was given, so that store grows with the source.
+	blockCh := make(chan blocks.Block)
+	tee := &teeBlockstore{Blockstore: c.bsvc.Blockstore(), ctx: ctx, blocks: blockCh}
+	dag := merkledag.NewDAGService(bserv.New(tee, c.bsvc.Exchange()))
+
 	dagFmtr, err := adder.NewAdder(ctx, dag)
 	if err != nil {
 		return cid.Undef, err
 	}
 
+	chunks := make(chan []byte)
+	assembleDone := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		assembleDone <- assembleCarChunks(ctx, blockCh, chunks)
+	}()
+
+	// The true root isn't known until dagFmtr.Add returns below, well
+	// after chunks have started being dispatched for upload, so unlike
+	// PutCar's single target CID we record every block CID any uploaded
+	// chunk contained and check the actual root for membership once it's
+	// known - same invariant, just checked the other way round.
+	uploaded := map[cid.Cid]struct{}{}
+	uploadDone := make(chan error, 1)
+	go func() {
+		uploadDone <- c.uploadChunks(ctx, chunks, func(buf []byte) error {
+			return recordCarChunkCids(buf, uploaded)
+		})
+	}()
+
 	root, err := dagFmtr.Add(file, cfg.dirname, cfg.fsys)
 	if err != nil {
+		cancel()
+		<-assembleDone
+		<-uploadDone
 		return cid.Undef, err
 	}
 
@@ -532,51 +787,211 @@ func (c *client) Put(ctx context.Context, file fs.File, options ...PutOption) (c
 		root = cnode.Cid()
 	}
 
-	// fmt.Println("root CID", root)
+	close(blockCh)
+
+	if err := <-assembleDone; err != nil {
+		return cid.Undef, err
+	}
+	if err := <-uploadDone; err != nil {
+		return cid.Undef, err
+	}
+	if _, ok := uploaded[root]; !ok {
+		return cid.Undef, fmt.Errorf("root block %s was not present in any uploaded car chunk", root)
+	}
+	return root, nil
+}
+
+// PutCar uploads a CAR (Content Addressable Archive) to Web3.Storage,
+// splitting it into targetChunkSize chunks and uploading up to
+// cfg.concurrency of them at once. Each chunk is retried independently
+// (see sendCar); if one fails permanently after exhausting retries, the
+// remaining in-flight chunks are cancelled and their error is returned.
+//
+// The returned CID is the root named in the CAR's header. Each chunk is
+// scanned for that block, and PutCar fails if no uploaded chunk holds it,
+// rather than assuming it's in the last chunk sent, since uploading
+// concurrently means chunks can complete in any order.
+func (c *client) PutCar(ctx context.Context, r io.Reader) (cid.Cid, error) {
+	br := bufio.NewReader(r)
+	header, err := car.ReadHeader(br)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("reading car header: %w", err)
+	}
+	if len(header.Roots) != 1 {
+		return cid.Undef, fmt.Errorf("expected exactly one car root, got %d", len(header.Roots))
+	}
+	root := header.Roots[0]
+
+	// carbites needs the header bytes it already consumed to be part of
+	// the stream it sees, so stitch them back onto the front.
+	var headerBuf bytes.Buffer
+	if err := car.WriteHeader(header, &headerBuf); err != nil {
+		return cid.Undef, err
+	}
+	full := io.MultiReader(&headerBuf, br)
 
-	carReader, carWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	carChunks := make(chan io.Reader)
+	splitDone := make(chan error, 1)
 	go func() {
-		err = car.WriteCar(ctx, dag, []cid.Cid{root}, carWriter)
-		if err != nil {
-			carWriter.CloseWithError(err)
-			return
+		defer close(carChunks)
+		splitDone <- carbites.Split(ctx, full, targetChunkSize, carbites.Treewalk, carChunks)
+	}()
+
+	chunks := make(chan []byte)
+	readDone := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		for chunk := range carChunks {
+			buf, err := io.ReadAll(chunk)
+			if err != nil {
+				readDone <- fmt.Errorf("reading car chunk: %w", err)
+				cancel()
+				return
+			}
+			select {
+			case chunks <- buf:
+			case <-ctx.Done():
+				readDone <- nil
+				return
+			}
 		}
-		carWriter.Close()
+		readDone <- nil
 	}()
 
-	return c.PutCar(ctx, carReader)
+	var rootFound int32
+	err = c.uploadChunks(ctx, chunks, func(buf []byte) error {
+		hasRoot, err := carChunkContainsBlock(buf, root)
+		if err != nil {
+			return err
+		}
+		if hasRoot {
+			atomic.StoreInt32(&rootFound, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		// A failed upload can leave the reader (and carbites behind it)
+		// blocked sending the next chunk; cancel so they can exit.
+		cancel()
+	}
+	// readDone's error, when there is one, is the actual cause of a
+	// cancellation that uploadChunks/splitDone would otherwise both just
+	// report as the less useful "context canceled" - surface it first.
+	if rerr := <-readDone; rerr != nil {
+		return cid.Undef, rerr
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := <-splitDone; err != nil {
+		return cid.Undef, err
+	}
+	if atomic.LoadInt32(&rootFound) == 0 {
+		return cid.Undef, fmt.Errorf("root block %s was not present in any uploaded car chunk", root)
+	}
+	return root, nil
 }
 
-// PutCar uploads a CAR (Content Addressable Archive) to Web3.Storage.
-func (c *client) PutCar(ctx context.Context, car io.Reader) (cid.Cid, error) {
-	carChunks := make(chan io.Reader)
+// uploadChunks uploads each chunk received on chunks, running up to
+// cfg.concurrency uploads at once. visit, if non-nil, is called
+// synchronously with a chunk's bytes before it is dispatched for upload,
+// so callers can inspect it (e.g. to find which chunk contains a
+// particular root block) without a second pass over the data. If an
+// upload fails permanently after exhausting sendCar's retries, or visit
+// returns an error, the remaining in-flight uploads are cancelled and
+// that error is returned.
+func (c *client) uploadChunks(ctx context.Context, chunks <-chan []byte, visit func([]byte) error) error {
+	workers := c.cfg.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
 
-	var root cid.Cid
-	var wg sync.WaitGroup
-	wg.Add(1)
+	g, gctx := errgroup.WithContext(ctx)
 
-	var sendErr error
-	go func() {
-		defer wg.Done()
-		for r := range carChunks {
-			// TODO: concurrency
-			c, err := c.sendCar(ctx, r)
-			if err != nil {
-				sendErr = err
-				break
+dispatch:
+	for {
+		select {
+		case buf, ok := <-chunks:
+			if !ok {
+				break dispatch
 			}
-			root = c
+			if visit != nil {
+				if err := visit(buf); err != nil {
+					// Report it through g so gctx is cancelled and the
+					// in-flight uploads stop before it's returned.
+					g.Go(func() error { return err })
+					break dispatch
+				}
+			}
+			if pt := progressTrackerFromContext(ctx); pt != nil {
+				pt.addTotal(int64(len(buf)))
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-gctx.Done():
+				break dispatch
+			}
+			g.Go(func() error {
+				defer func() { <-sem }()
+				_, err := c.sendCar(gctx, bytes.NewReader(buf))
+				return err
+			})
+		case <-gctx.Done():
+			break dispatch
 		}
-	}()
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	// Dispatch can reach here via gctx.Done() before any worker has had
+	// a chance to observe it and return an error of its own - make sure
+	// cancellation is still reported promptly in that case too.
+	return ctx.Err()
+}
 
-	err := carbites.Split(ctx, car, targetChunkSize, carbites.Treewalk, carChunks)
+// carChunkContainsBlock reports whether carBytes, a standalone CAR chunk
+// produced by carbites, contains the block identified by target.
+func carChunkContainsBlock(carBytes []byte, target cid.Cid) (bool, error) {
+	cr, err := car.NewCarReader(bytes.NewReader(carBytes))
 	if err != nil {
-		return cid.Undef, err
+		return false, err
+	}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if blk.Cid().Equals(target) {
+			return true, nil
+		}
 	}
-	wg.Wait()
+}
 
-	return root, sendErr
+// recordCarChunkCids adds the CID of every block in carBytes, a standalone
+// CAR chunk produced by carbites, to seen.
+func recordCarChunkCids(carBytes []byte, seen map[cid.Cid]struct{}) error {
+	cr, err := car.NewCarReader(bytes.NewReader(carBytes))
+	if err != nil {
+		return err
+	}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		seen[blk.Cid()] = struct{}{}
+	}
 }
 
 func (c *client) Status(ctx context.Context, cid cid.Cid) (*Status, error) {
@@ -0,0 +1,88 @@
+package w3s
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCarHandler responds to /car uploads by reading the request body
+// (so sendCar's Seek/retry loop sees a real chunk) and then blocking until
+// the request's context is done, simulating a chunk upload that hangs.
+func blockingCarHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1)
+		_, _ = r.Body.Read(buf)
+		<-r.Context().Done()
+	}
+}
+
+func TestSendCarCancellation(t *testing.T) {
+	srv := httptest.NewServer(blockingCarHandler())
+	defer srv.Close()
+
+	c, err := NewClient(WithToken("test"), WithEndpoint(srv.URL))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.(*client).sendCar(ctx, strings.NewReader("chunk data"))
+	elapsed := time.Since(start)
+
+	require.Error(t, err)
+	require.Less(t, elapsed, 2*time.Second, "sendCar should return promptly once ctx is cancelled, not block until the server responds")
+}
+
+func TestUploadChunksCancellation(t *testing.T) {
+	srv := httptest.NewServer(blockingCarHandler())
+	defer srv.Close()
+
+	c, err := NewClient(WithToken("test"), WithEndpoint(srv.URL), WithConcurrency(2))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks := make(chan []byte)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.(*client).uploadChunks(ctx, chunks, nil)
+	}()
+
+	// Hand the worker pool one chunk, which the handler above will block
+	// on forever, then cancel the whole operation instead of waiting for
+	// the server.
+	chunks <- []byte("chunk data")
+	close(chunks)
+	cancel()
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("uploadChunks did not return within bound after ctx cancellation")
+	}
+}
+
+func TestSetUploadDeadlineCancelsInFlightUpload(t *testing.T) {
+	srv := httptest.NewServer(blockingCarHandler())
+	defer srv.Close()
+
+	c, err := NewClient(WithToken("test"), WithEndpoint(srv.URL))
+	require.NoError(t, err)
+
+	start := time.Now()
+	c.SetUploadDeadline(start.Add(50 * time.Millisecond))
+
+	_, err = c.(*client).sendCar(context.Background(), strings.NewReader("chunk data"))
+	elapsed := time.Since(start)
+
+	require.Error(t, err)
+	require.Less(t, elapsed, 2*time.Second, "SetUploadDeadline should cancel the in-flight sendCar call once it fires")
+}
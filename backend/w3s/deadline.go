@@ -0,0 +1,69 @@
+package w3s
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// deadlineTimer gives an operation that has no built-in timeout (like a
+// chunk upload that can legitimately take a while) the same
+// SetDeadline/cancel-channel shape net.Conn uses internally: a timer
+// closes a channel when the deadline fires, and callers select on that
+// channel alongside their own work to unblock promptly instead of
+// blocking until the deadline passes on its own.
+type deadlineTimer struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadlineTimer() *deadlineTimer {
+	return &deadlineTimer{cancel: make(chan struct{})}
+}
+
+// setDeadline arms the timer to close the channel returned by done at t,
+// replacing any previously armed one. A zero t disarms it.
+func (d *deadlineTimer) setDeadline(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// The previous timer has fired or is firing; wait for it to close
+		// the channel so it's replaced below rather than closed twice.
+		<-d.cancel
+	}
+	select {
+	case <-d.cancel:
+		d.cancel = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		d.timer = nil
+		return
+	}
+	cancel := d.cancel
+	d.timer = time.AfterFunc(time.Until(t), func() { close(cancel) })
+}
+
+// done returns the channel that closes when the deadline armed by
+// setDeadline fires.
+func (d *deadlineTimer) done() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// withDeadlineTimer returns a context derived from parent that's also
+// cancelled when dt's deadline fires.
+func withDeadlineTimer(parent context.Context, dt *deadlineTimer) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	done := dt.done()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,122 @@
+package w3s
+
+import (
+	"io/fs"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// Option is a function that configures a Client.
+type Option func(*clientConfig) error
+
+// WithToken sets the auth token used to authenticate with the web3.storage API.
+func WithToken(token string) Option {
+	return func(cfg *clientConfig) error {
+		cfg.token = token
+		return nil
+	}
+}
+
+// WithEndpoint sets the web3.storage API endpoint. Defaults to
+// https://api.web3.storage.
+func WithEndpoint(endpoint string) Option {
+	return func(cfg *clientConfig) error {
+		cfg.endpoint = endpoint
+		return nil
+	}
+}
+
+// WithIpfsEndpoint sets the public IPFS gateway endpoint used for the
+// IPFS-native read paths. Defaults to http://dweb.link.
+func WithIpfsEndpoint(endpoint string) Option {
+	return func(cfg *clientConfig) error {
+		cfg.ipfsEndpoint = endpoint
+		return nil
+	}
+}
+
+// WithDatastore sets the datastore used to back the client's local
+// blockstore. If not set, an in-memory datastore is used.
+func WithDatastore(dstore ds.Batching) Option {
+	return func(cfg *clientConfig) error {
+		cfg.ds = dstore
+		return nil
+	}
+}
+
+// WithConcurrency sets the number of CAR chunks that may be uploaded to
+// web3.storage at the same time by PutCar. Defaults to 4.
+func WithConcurrency(n int) Option {
+	return func(cfg *clientConfig) error {
+		cfg.concurrency = n
+		return nil
+	}
+}
+
+// WithCARCache backs the client's local blockstore with a CARv2 file at
+// path instead of the default in-memory one. Blocks fetched by Get and
+// GetSelector are written into it, and a later GetSelector call for a
+// root already in it is served locally instead of re-hitting the
+// gateway. Get always requests the CAR from the endpoint. The file is
+// created if it doesn't already exist. Long-lived callers (e.g. an
+// rclone mount) should call Client.Compact periodically so it doesn't
+// grow unbounded.
+func WithCARCache(path string) Option {
+	return func(cfg *clientConfig) error {
+		cfg.carCachePath = path
+		return nil
+	}
+}
+
+// WithRequestTimeout bounds how long a single HTTP request is allowed to
+// take, independent of whatever deadline ctx itself carries. It applies
+// to each chunk upload attempt, so it does not bound a whole PutCar call,
+// which may make many of them; to Get, GetUsingIpfs and GetIpfsFile until
+// the returned body is closed; and to a whole GetSelector call. Status,
+// StatusIpfs, List and PutRclone's rename request are not bounded by it.
+// Use Client.SetUploadDeadline/SetDownloadDeadline to bound whole uploads
+// and downloads.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(cfg *clientConfig) error {
+		cfg.requestTimeout = d
+		return nil
+	}
+}
+
+// PutOption is a function that configures a Put.
+type PutOption func(*putConfig) error
+
+// WithDirname sets the name to use for the root directory when the file
+// passed to Put is a directory; it is also the path walked within the
+// fs.FS set by WithFS. If not set, the directory's own name (from its
+// Stat) is used.
+func WithDirname(name string) PutOption {
+	return func(cfg *putConfig) error {
+		cfg.dirname = name
+		return nil
+	}
+}
+
+// WithFS sets the fs.FS used to read directory contents. Put requires it
+// whenever the file passed to it is a directory; a directory is not read
+// through fs.ReadDirFile.
+func WithFS(fsys fs.FS) PutOption {
+	return func(cfg *putConfig) error {
+		cfg.fsys = fsys
+		return nil
+	}
+}
+
+// WithProgress registers cb to be called as the upload progresses. sent
+// and total are measured in uploaded CAR bytes, not source bytes - the
+// two differ because of UnixFS chunking and CAR framing - and total
+// grows as additional chunks are discovered rather than being known up
+// front. cb may be called concurrently from multiple chunk uploads in
+// flight at once.
+func WithProgress(cb func(sent, total int64)) PutOption {
+	return func(cfg *putConfig) error {
+		cfg.progress = cb
+		return nil
+	}
+}
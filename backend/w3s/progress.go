@@ -0,0 +1,76 @@
+package w3s
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// progressTracker aggregates upload progress across every chunk in
+// flight for a single Put/PutCar call, reporting sent/total as uploaded
+// CAR bytes. sent only ever grows by bytes from attempts that actually
+// completed - a chunk retried after a partial read reports that read
+// again from zero on the next attempt rather than adding to what the
+// failed attempt already reported, so sent can't overshoot total for a
+// chunk that needed retries.
+type progressTracker struct {
+	cb    func(sent, total int64)
+	sent  int64
+	total int64
+}
+
+// addTotal records n more bytes as having been queued for upload.
+func (p *progressTracker) addTotal(n int64) {
+	t := atomic.AddInt64(&p.total, n)
+	p.cb(atomic.LoadInt64(&p.sent), t)
+}
+
+// commit adds n bytes read by a completed attempt to the shared sent count.
+func (p *progressTracker) commit(n int64) {
+	s := atomic.AddInt64(&p.sent, n)
+	p.cb(s, atomic.LoadInt64(&p.total))
+}
+
+// wrap returns r instrumented to report live progress as bytes are read
+// from it (the shared sent count plus however much this attempt has read
+// so far), and a commit func the caller must invoke once this attempt has
+// actually completed, which folds those bytes into the shared sent count
+// for good. Until commit is called, a failed attempt's reads are never
+// reflected in the shared count, so a retry starting over from byte zero
+// doesn't double-count them.
+func (p *progressTracker) wrap(r io.Reader) (_ io.Reader, commit func()) {
+	pr := &progressReader{r: r, p: p}
+	return pr, func() { p.commit(pr.n) }
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progressTracker
+	n int64
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.n += int64(n)
+		pr.p.cb(atomic.LoadInt64(&pr.p.sent)+pr.n, atomic.LoadInt64(&pr.p.total))
+	}
+	return n, err
+}
+
+type progressCtxKey struct{}
+
+// WithProgressContext attaches a progress callback to ctx so that a
+// direct PutCar call reports upload progress the same way Put does for
+// its WithProgress PutOption - PutCar's signature is part of the Client
+// interface and has no room for options, so context is the only place
+// left to hang this off of. sent and total are uploaded CAR bytes, not
+// source bytes.
+func WithProgressContext(ctx context.Context, cb func(sent, total int64)) context.Context {
+	return context.WithValue(ctx, progressCtxKey{}, &progressTracker{cb: cb})
+}
+
+func progressTrackerFromContext(ctx context.Context) *progressTracker {
+	pt, _ := ctx.Value(progressCtxKey{}).(*progressTracker)
+	return pt
+}
@@ -0,0 +1,258 @@
+package w3s
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	files "github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	carv2bs "github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+	textselector "github.com/ipld/go-ipld-selector-text-lite"
+)
+
+// GetSelector walks sel over the DAG of root and writes the matched
+// UnixFS file(s) to w. Like Get, it downloads the whole CAR for root from
+// /car/{root}; only the matched files are written out. When the client
+// was configured with WithCARCache, that persistent blockstore is used
+// (and populated) directly, so a selector walk over a root it already has
+// cached doesn't re-hit the gateway at all; otherwise a disposable CARv2
+// blockstore is used.
+func (c *client) GetSelector(ctx context.Context, root cid.Cid, sel ipld.Node, w io.Writer) error {
+	// Safe to defer-cancel here, unlike in Get: the whole fetch (and the
+	// walk over it below) completes before GetSelector returns, rather
+	// than handing back a reader the caller keeps pulling from.
+	ctx, cancel := withDeadlineTimer(ctx, c.downloadDeadline)
+	defer cancel()
+	if c.cfg.requestTimeout > 0 {
+		var rcancel context.CancelFunc
+		ctx, rcancel = context.WithTimeout(ctx, c.cfg.requestTimeout)
+		defer rcancel()
+	}
+
+	var bs blockstore.Blockstore
+	finalize := func() error { return nil }
+	if c.carCache != nil {
+		bs = c.carCache
+	} else {
+		f, err := ioutil.TempFile("", "w3s-*.car")
+		if err != nil {
+			return err
+		}
+		path := f.Name()
+		f.Close()
+		defer os.Remove(path)
+
+		cbs, err := carv2bs.OpenReadWrite(path, []cid.Cid{root})
+		if err != nil {
+			return err
+		}
+		bs = cbs
+		finalize = cbs.Finalize
+	}
+
+	if has, err := bs.Has(root); err != nil {
+		return err
+	} else if !has {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/car/%s", c.cfg.endpoint, root), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.cfg.token))
+		res, err := c.hc.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return fmt.Errorf("unexpected response status: %d", res.StatusCode)
+		}
+
+		cr, err := car.NewCarReader(res.Body)
+		if err != nil {
+			return err
+		}
+		for {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := bs.Put(blk); err != nil {
+				return err
+			}
+		}
+		if err := finalize(); err != nil {
+			return err
+		}
+	}
+
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", lnk)
+		}
+		blk, err := bs.Get(cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(blk.RawData()), nil
+	}
+
+	rootNode, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, basicnode.Prototype.Any)
+	if err != nil {
+		return err
+	}
+
+	dag := merkledag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	progress := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:        ctx,
+			LinkSystem: lsys,
+			LinkTargetNodePrototypeChooser: func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+				return basicnode.Prototype.Any, nil
+			},
+		},
+	}
+
+	return progress.WalkMatching(rootNode, sel, func(p traversal.Progress, n ipld.Node) error {
+		// The traversal loads links before visiting, so a matched node is
+		// a block's content, never a link. A match inside a block (e.g. a
+		// matched scalar) has no file of its own - nothing to stream.
+		if p.LastBlock.Path.String() != p.Path.String() {
+			return nil
+		}
+		lnk := p.LastBlock.Link
+		if lnk == nil {
+			lnk = cidlink.Link{Cid: root}
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return fmt.Errorf("unsupported link type: %T", lnk)
+		}
+		nd, err := dag.Get(ctx, cl.Cid)
+		if err != nil {
+			return err
+		}
+		uf, err := unixfile.NewUnixfsFile(ctx, dag, nd)
+		if err != nil {
+			return err
+		}
+		fr, ok := uf.(files.File)
+		if !ok {
+			return fmt.Errorf("matched node %s is not a file", cl.Cid)
+		}
+		_, err = io.Copy(w, fr)
+		return err
+	})
+}
+
+// GetPath is a convenience wrapper around GetSelector that accepts a
+// textselector path such as "Links/0/Hash/foo" instead of a pre-built
+// ipld.Node selector.
+func (c *client) GetPath(ctx context.Context, root cid.Cid, path string, w io.Writer) error {
+	spec, err := textselector.SelectorSpecFromPath(textselector.Expression(path), false, nil)
+	if err != nil {
+		return fmt.Errorf("parsing selector path %q: %w", path, err)
+	}
+	return c.GetSelector(ctx, root, spec.Node(), w)
+}
+
+// PutCarWithSelector uploads only the blocks of car that are reachable
+// from root via sel, so a caller updating a slice of a large dataset
+// doesn't have to re-ship the whole thing.
+func (c *client) PutCarWithSelector(ctx context.Context, r io.Reader, sel ipld.Node) (cid.Cid, error) {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(cr.Header.Roots) != 1 {
+		return cid.Undef, fmt.Errorf("expected exactly one car root, got %d", len(cr.Header.Roots))
+	}
+	root := cr.Header.Roots[0]
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := bs.Put(blk); err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	visited := map[cid.Cid]struct{}{root: {}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", lnk)
+		}
+		blk, err := bs.Get(cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		visited[cl.Cid] = struct{}{}
+		return bytes.NewReader(blk.RawData()), nil
+	}
+
+	rootNode, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, basicnode.Prototype.Any)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	progress := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:        ctx,
+			LinkSystem: lsys,
+			LinkTargetNodePrototypeChooser: func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+				return basicnode.Prototype.Any, nil
+			},
+		},
+	}
+	if err := progress.WalkMatching(rootNode, sel, func(traversal.Progress, ipld.Node) error {
+		return nil
+	}); err != nil {
+		return cid.Undef, err
+	}
+
+	var buf bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, &buf); err != nil {
+		return cid.Undef, err
+	}
+	for blkCID := range visited {
+		blk, err := bs.Get(blkCID)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := util.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	return c.PutCar(ctx, &buf)
+}
@@ -0,0 +1,102 @@
+package w3s
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+// teeBlockstore wraps a blockstore.Blockstore and, in addition to
+// storing blocks as normal (so the DAG builder writing through it can
+// still read back nodes it just wrote), pushes every newly stored block
+// onto a channel so a consumer can stream them out without waiting for
+// the whole DAG to be built.
+type teeBlockstore struct {
+	blockstore.Blockstore
+	ctx    context.Context
+	blocks chan<- blocks.Block
+}
+
+func (t *teeBlockstore) Put(b blocks.Block) error {
+	if err := t.Blockstore.Put(b); err != nil {
+		return err
+	}
+	select {
+	case t.blocks <- b:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+func (t *teeBlockstore) PutMany(bs []blocks.Block) error {
+	for _, b := range bs {
+		if err := t.Put(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assembleCarChunks reads blocks as dagFmtr.Add produces them and groups
+// them into CAR chunks of about targetChunkSize, emitting each one on
+// dst as soon as it's full and a final, possibly smaller one once src is
+// closed.
+//
+// The dataset's real root isn't known until the whole DAG has been
+// built, so each chunk's header instead names that chunk's own first
+// block as a placeholder root - just enough for the chunk to be a valid,
+// self-contained CAR. The caller (Put) already knows the true root by
+// the time it needs it, from dagFmtr.Add or, for a directory, from the
+// MFS root Add filled.
+func assembleCarChunks(ctx context.Context, src <-chan blocks.Block, dst chan<- []byte) error {
+	var body bytes.Buffer
+	var first cid.Cid
+
+	flush := func() error {
+		if body.Len() == 0 {
+			return nil
+		}
+		var chunk bytes.Buffer
+		if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{first}, Version: 1}, &chunk); err != nil {
+			return err
+		}
+		chunk.Write(body.Bytes())
+		select {
+		case dst <- chunk.Bytes():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		body.Reset()
+		first = cid.Undef
+		return nil
+	}
+
+	for {
+		select {
+		case blk, ok := <-src:
+			if !ok {
+				return flush()
+			}
+			if !first.Defined() {
+				first = blk.Cid()
+			}
+			if err := util.LdWrite(&body, blk.Cid().Bytes(), blk.RawData()); err != nil {
+				return err
+			}
+			if body.Len() >= targetChunkSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}